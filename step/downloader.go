@@ -0,0 +1,82 @@
+package step
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// Downloader fetches a cache archive matching one of the given keys and
+// reports which key it used. DownloadService (S3), GCSDownloadService and
+// LocalDownloadService all implement it, so the step can target non-AWS
+// Bitrise deployments without forking.
+type Downloader interface {
+	Download(ctx context.Context, params network.DownloadParams, logger log.Logger) (string, error)
+}
+
+var (
+	_ Downloader = DownloadService{}
+	_ Downloader = GCSDownloadService{}
+	_ Downloader = LocalDownloadService{}
+)
+
+// Provider selects which storage backend a Downloader targets.
+type Provider string
+
+const (
+	ProviderS3    Provider = "s3"
+	ProviderGCS   Provider = "gcs"
+	ProviderLocal Provider = "local"
+)
+
+// DownloaderConfig carries the settings needed to build a Downloader.
+// Provider picks the backend explicitly; if empty, it's inferred from a
+// s3://, gs:// or file:// scheme on Bucket. The backend-specific structs
+// carry every other setting (credentials, encryption, etc) for their
+// respective provider.
+type DownloaderConfig struct {
+	Provider Provider
+	Bucket   string
+	S3       DownloadService
+	GCS      GCSDownloadService
+	Local    LocalDownloadService
+}
+
+// NewDownloader builds the Downloader matching cfg.Provider, or the provider
+// implied by a URL scheme on cfg.Bucket when Provider is empty.
+func NewDownloader(cfg DownloaderConfig) (Downloader, error) {
+	provider, bucket := cfg.Provider, cfg.Bucket
+
+	if provider == "" {
+		switch {
+		case strings.HasPrefix(bucket, "s3://"):
+			provider, bucket = ProviderS3, strings.TrimPrefix(bucket, "s3://")
+		case strings.HasPrefix(bucket, "gs://"):
+			provider, bucket = ProviderGCS, strings.TrimPrefix(bucket, "gs://")
+		case strings.HasPrefix(bucket, "file://"):
+			provider, bucket = ProviderLocal, strings.TrimPrefix(bucket, "file://")
+		default:
+			provider = ProviderS3
+		}
+	}
+
+	switch provider {
+	case ProviderS3:
+		svc := cfg.S3
+		svc.Bucket = bucket
+		return svc, nil
+	case ProviderGCS:
+		svc := cfg.GCS
+		svc.Bucket = bucket
+		return svc, nil
+	case ProviderLocal:
+		svc := cfg.Local
+		svc.Dir = bucket
+		return svc, nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+}
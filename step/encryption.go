@@ -0,0 +1,153 @@
+package step
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// clientSideEncryptedKeyMetadata is the object metadata key holding the
+// base64-encoded, KMS-wrapped data key for client-side envelope encryption.
+const clientSideEncryptedKeyMetadata = "x-amz-key"
+
+// clientSideChunkSize is the plaintext size of each independently-sealed GCM
+// segment that makes up a client-side encrypted archive. Chunking keeps
+// memory use bounded (archives are routinely hundreds of MB to multiple GB)
+// and avoids AES-GCM's per-message size ceiling.
+const clientSideChunkSize = 1 << 20 // 1MB
+
+// chunkLengthPrefixSize is the size, in bytes, of the length prefix written
+// before each encrypted segment (nonce + ciphertext + tag).
+const chunkLengthPrefixSize = 4
+
+// decryptClientSide decrypts a downloaded archive using AES-256-GCM, one
+// length-prefixed segment at a time (whatever size each prefix records), so
+// the whole archive is never held in memory at once. The data key comes from ClientSideKey directly, or, if
+// that's empty, from unwrapping the object's KMS-encrypted data key (stored
+// in metadata under clientSideEncryptedKeyMetadata) via ClientSideKMSKeyARN.
+func (s DownloadService) decryptClientSide(ctx context.Context, path string, metadata map[string]string) error {
+	dataKey, err := s.resolveClientSideDataKey(ctx, metadata)
+	if err != nil {
+		return fmt.Errorf("resolve data key: %w", err)
+	}
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return fmt.Errorf("create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("create gcm: %w", err)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open encrypted archive: %w", err)
+	}
+	defer src.Close() //nolint:errcheck
+
+	dst, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".decrypting-*")
+	if err != nil {
+		return fmt.Errorf("create decrypted archive: %w", err)
+	}
+	tmpPath := dst.Name()
+	defer os.Remove(tmpPath) //nolint:errcheck // no-op once renamed onto path below
+
+	if err := streamDecryptGCM(src, dst, gcm); err != nil {
+		dst.Close() //nolint:errcheck,gosec
+		return fmt.Errorf("stream decrypt: %w", err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("close decrypted archive: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replace archive with decrypted contents: %w", err)
+	}
+
+	return nil
+}
+
+// streamDecryptGCM reads length-prefixed, independently-sealed GCM segments
+// from src and writes their decrypted plaintext to dst one segment at a
+// time, so only a single segment is ever held in memory.
+func streamDecryptGCM(src io.Reader, dst io.Writer, gcm cipher.AEAD) error {
+	lengthBuf := make([]byte, chunkLengthPrefixSize)
+
+	for {
+		if _, err := io.ReadFull(src, lengthBuf); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read chunk length: %w", err)
+		}
+
+		segment := make([]byte, binary.BigEndian.Uint32(lengthBuf))
+		if _, err := io.ReadFull(src, segment); err != nil {
+			return fmt.Errorf("read chunk: %w", err)
+		}
+
+		if len(segment) < gcm.NonceSize() {
+			return fmt.Errorf("encrypted chunk is shorter than the GCM nonce size")
+		}
+		nonce, ciphertext := segment[:gcm.NonceSize()], segment[gcm.NonceSize():]
+
+		plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("decrypt chunk: %w", err)
+		}
+
+		if _, err := dst.Write(plaintext); err != nil {
+			return fmt.Errorf("write chunk: %w", err)
+		}
+	}
+}
+
+func (s DownloadService) resolveClientSideDataKey(ctx context.Context, metadata map[string]string) ([]byte, error) {
+	if s.ClientSideKey != "" {
+		dataKey, err := base64.StdEncoding.DecodeString(s.ClientSideKey)
+		if err != nil {
+			return nil, fmt.Errorf("decode client-side key: %w", err)
+		}
+		return dataKey, nil
+	}
+
+	if s.ClientSideKMSKeyARN == "" {
+		return nil, fmt.Errorf("neither a client-side key nor a KMS key arn was provided")
+	}
+
+	wrappedKey, ok := metadata[clientSideEncryptedKeyMetadata]
+	if !ok || wrappedKey == "" {
+		return nil, fmt.Errorf("archive metadata is missing the wrapped data key (%s)", clientSideEncryptedKeyMetadata)
+	}
+
+	wrappedKeyBytes, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode wrapped data key: %w", err)
+	}
+
+	if s.kmsClient == nil {
+		return nil, fmt.Errorf("kms client not configured")
+	}
+
+	out, err := s.kmsClient.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: wrappedKeyBytes,
+		KeyId:          &s.ClientSideKMSKeyARN,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key via kms: %w", err)
+	}
+
+	return out.Plaintext, nil
+}
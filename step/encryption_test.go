@@ -0,0 +1,115 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sealClientSide encrypts plaintext into the length-prefixed, chunked GCM
+// segment format streamDecryptGCM expects, using chunkSize-sized plaintext
+// segments so tests can exercise the multi-segment path.
+func sealClientSide(t *testing.T, gcm cipher.AEAD, plaintext []byte, chunkSize int) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	for offset := 0; offset < len(plaintext); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatalf("generate nonce: %s", err)
+		}
+
+		sealed := gcm.Seal(nil, nonce, plaintext[offset:end], nil)
+		segment := append(nonce, sealed...)
+
+		lengthBuf := make([]byte, chunkLengthPrefixSize)
+		binary.BigEndian.PutUint32(lengthBuf, uint32(len(segment)))
+
+		out.Write(lengthBuf)
+		out.Write(segment)
+	}
+
+	return out.Bytes()
+}
+
+func TestDecryptClientSide_RoundTrip(t *testing.T) {
+	rawKey := make([]byte, 32)
+	if _, err := rand.Read(rawKey); err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		t.Fatalf("create cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("create gcm: %s", err)
+	}
+
+	plaintext := bytes.Repeat([]byte("archive-contents-"), 10000) // spans multiple small chunks below
+
+	archivePath := filepath.Join(t.TempDir(), "archive.tzst")
+	if err := os.WriteFile(archivePath, sealClientSide(t, gcm, plaintext, 4096), 0o600); err != nil {
+		t.Fatalf("write sealed archive: %s", err)
+	}
+
+	s := DownloadService{ClientSideEncryption: true, ClientSideKey: base64.StdEncoding.EncodeToString(rawKey)}
+
+	if err := s.decryptClientSide(context.Background(), archivePath, nil); err != nil {
+		t.Fatalf("decryptClientSide returned an error: %s", err)
+	}
+
+	got, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("read decrypted archive: %s", err)
+	}
+
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypted contents do not match the original plaintext (got %d bytes, want %d bytes)", len(got), len(plaintext))
+	}
+}
+
+func TestDecryptClientSide_WrongKeyFails(t *testing.T) {
+	rawKey := make([]byte, 32)
+	if _, err := rand.Read(rawKey); err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		t.Fatalf("create cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("create gcm: %s", err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "archive.tzst")
+	if err := os.WriteFile(archivePath, sealClientSide(t, gcm, []byte("secret contents"), clientSideChunkSize), 0o600); err != nil {
+		t.Fatalf("write sealed archive: %s", err)
+	}
+
+	wrongKey := make([]byte, 32)
+	if _, err := rand.Read(wrongKey); err != nil {
+		t.Fatalf("generate wrong key: %s", err)
+	}
+
+	s := DownloadService{ClientSideEncryption: true, ClientSideKey: base64.StdEncoding.EncodeToString(wrongKey)}
+
+	if err := s.decryptClientSide(context.Background(), archivePath, nil); err == nil {
+		t.Fatal("expected decryption with the wrong key to fail")
+	}
+}
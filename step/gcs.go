@@ -0,0 +1,141 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/retry"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"google.golang.org/api/iterator"
+)
+
+// GCSDownloadService restores a cache archive from a Google Cloud Storage
+// bucket, selected via the gs:// URL scheme or an explicit provider input.
+type GCSDownloadService struct {
+	Client *storage.Client
+	Bucket string
+}
+
+// Download archive from the provided GCS bucket based on the provided keys
+// in params. If there is no match for any of the keys, the error is
+// errCacheNotFound.
+func (s GCSDownloadService) Download(ctx context.Context, params network.DownloadParams, logger log.Logger) (string, error) {
+	truncatedKeys, err := validateKeys(params.CacheKeys)
+	if err != nil {
+		return "", fmt.Errorf("validate keys: %w", err)
+	}
+
+	if s.Bucket == "" {
+		return "", fmt.Errorf("bucket must not be empty")
+	}
+
+	if s.Client == nil {
+		s.Client, err = storage.NewClient(ctx)
+		if err != nil {
+			return "", fmt.Errorf("create gcs client: %w", err)
+		}
+		defer s.Client.Close() //nolint:errcheck
+	}
+
+	bucket := s.Client.Bucket(s.Bucket)
+
+	match, err := firstAvailableGCSKey(ctx, bucket, truncatedKeys, logger)
+	if err != nil {
+		if !errors.Is(err, errKeyNotFound) {
+			return "", fmt.Errorf("matching key: %w", err)
+		}
+
+		logger.Debugf("Could not match provided cache keys, falling back to find archive by prefix...")
+		match, err = firstAvailableGCSKeyWithPrefix(ctx, bucket, truncatedKeys)
+		if err != nil {
+			if errors.Is(err, errKeyNotFound) {
+				return "", errCacheNotFound
+			}
+			return "", fmt.Errorf("finding archive by prefix: %w", err)
+		}
+	}
+
+	err = retry.Times(uint(params.NumFullRetries)).Wait(5 * time.Second).TryWithAbort(func(attempt uint) (error, bool) {
+		if err := downloadGCSObject(ctx, bucket, match.objectName, params.DownloadPath); err != nil {
+			return fmt.Errorf("download object: %w", err), false
+		}
+
+		return nil, true
+	})
+	if err != nil {
+		return "", fmt.Errorf("all retries failed: %w", err)
+	}
+
+	return match.key, nil
+}
+
+// gcsKeyMatch pairs the user-declared cache key with the actual GCS object
+// name that was confirmed to exist, so the object fetched later is always the
+// one that was just checked for existence.
+type gcsKeyMatch struct {
+	key        string
+	objectName string
+}
+
+func firstAvailableGCSKey(ctx context.Context, bucket *storage.BucketHandle, keys []string, logger log.Logger) (gcsKeyMatch, error) {
+	for _, key := range keys {
+		fileKey := strings.Join([]string{key, "tzst"}, ".")
+
+		if _, err := bucket.Object(fileKey).Attrs(ctx); err != nil {
+			if errors.Is(err, storage.ErrObjectNotExist) {
+				logger.Debugf("archive with key %s not found in bucket", key)
+				continue
+			}
+			return gcsKeyMatch{}, fmt.Errorf("gcs error: %w", err)
+		}
+
+		return gcsKeyMatch{key: key, objectName: fileKey}, nil
+	}
+
+	return gcsKeyMatch{}, errKeyNotFound
+}
+
+func firstAvailableGCSKeyWithPrefix(ctx context.Context, bucket *storage.BucketHandle, keys []string) (gcsKeyMatch, error) {
+	for _, key := range keys {
+		it := bucket.Objects(ctx, &storage.Query{Prefix: key})
+
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			continue
+		}
+		if err != nil {
+			return gcsKeyMatch{}, fmt.Errorf("find artifact for key prefix: %w", err)
+		}
+
+		return gcsKeyMatch{key: attrs.Name, objectName: attrs.Name}, nil
+	}
+
+	return gcsKeyMatch{}, errKeyNotFound
+}
+
+func downloadGCSObject(ctx context.Context, bucket *storage.BucketHandle, key string, downloadPath string) error {
+	file, err := os.Create(downloadPath)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	reader, err := bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("new reader: %w", err)
+	}
+	defer reader.Close() //nolint:errcheck
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return fmt.Errorf("get object: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package step
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // required to match S3's sha1 object checksum, not used for security here.
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// manifestSidecarSuffix is appended to a cache archive's key (with any ".tzst"
+// extension stripped first) to look up a sibling object recording its expected sha256 digest, for
+// archives that weren't uploaded with an S3 checksum algorithm.
+const manifestSidecarSuffix = ".tzst.sha256"
+
+// errArchiveCorrupted is returned when a downloaded archive fails integrity
+// verification, so callers can distinguish corruption from a network failure.
+var errArchiveCorrupted = errors.New("downloaded archive failed integrity verification")
+
+// verifyIntegrity checks the downloaded archive at path against S3's stored
+// checksum (when the object was uploaded with ChecksumAlgorithm) or, failing
+// that, a manifest sidecar object. If no reference checksum is available,
+// verification is skipped. On mismatch the partial file is deleted.
+func (s DownloadService) verifyIntegrity(ctx context.Context, key string, path string, logger log.Logger) error {
+	expected, err := s.expectedSHA256(ctx, key)
+	if err != nil {
+		return fmt.Errorf("determine expected checksum: %w", err)
+	}
+	if expected.hexDigest == "" {
+		logger.Debugf("no reference checksum available for %s, skipping integrity verification", key)
+		return nil
+	}
+
+	actual, err := fileChecksum(path, expected.algorithm)
+	if err != nil {
+		return fmt.Errorf("compute archive checksum: %w", err)
+	}
+
+	if !strings.EqualFold(actual, expected.hexDigest) {
+		if removeErr := os.Remove(path); removeErr != nil {
+			logger.Debugf("could not remove corrupted archive %s: %s", path, removeErr)
+		}
+		return fmt.Errorf("%w: expected %s %s, got %s", errArchiveCorrupted, expected.algorithm, expected.hexDigest, actual)
+	}
+
+	return nil
+}
+
+// expectedChecksum identifies which algorithm a reference digest was computed
+// with, since S3's stored object checksum isn't always a sha256.
+type expectedChecksum struct {
+	algorithm string
+	hexDigest string
+}
+
+// expectedSHA256 returns the hex-encoded digest to verify the archive
+// against and the algorithm it was computed with, or an empty digest if none
+// could be found. S3 returns whichever checksum algorithm the object was
+// uploaded with (sha256, crc32c, crc32 or sha1); a manifest sidecar is always
+// assumed to be sha256.
+func (s DownloadService) expectedSHA256(ctx context.Context, key string) (expectedChecksum, error) {
+	sseParams, err := s.sseCustomerParams()
+	if err != nil {
+		return expectedChecksum{}, fmt.Errorf("sse-c params: %w", err)
+	}
+
+	head, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(key),
+		ChecksumMode:         types.ChecksumModeEnabled,
+		SSECustomerAlgorithm: sseParams.Algorithm,
+		SSECustomerKey:       sseParams.Key,
+		SSECustomerKeyMD5:    sseParams.KeyMD5,
+	})
+	if err != nil {
+		return expectedChecksum{}, fmt.Errorf("head object: %w", err)
+	}
+
+	for algorithm, checksum := range map[string]*string{
+		"sha256": head.ChecksumSHA256,
+		"sha1":   head.ChecksumSHA1,
+		"crc32c": head.ChecksumCRC32C,
+		"crc32":  head.ChecksumCRC32,
+	} {
+		if checksum == nil || *checksum == "" {
+			continue
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(*checksum)
+		if err != nil {
+			return expectedChecksum{}, fmt.Errorf("decode x-amz-checksum-%s: %w", algorithm, err)
+		}
+		return expectedChecksum{algorithm: algorithm, hexDigest: hex.EncodeToString(raw)}, nil
+	}
+
+	digest, err := s.manifestSHA256(ctx, key)
+	if err != nil || digest == "" {
+		return expectedChecksum{}, err
+	}
+	return expectedChecksum{algorithm: "sha256", hexDigest: digest}, nil
+}
+
+// manifestSHA256 looks for a sibling "<key>.tzst.sha256" object and returns
+// the hex digest it records, or an empty string if no such sidecar exists.
+func (s DownloadService) manifestSHA256(ctx context.Context, key string) (string, error) {
+	sidecarKey := strings.TrimSuffix(key, ".tzst") + manifestSidecarSuffix
+
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(sidecarKey),
+	})
+	if err != nil {
+		var apiError *types.NoSuchKey
+		if errors.As(err, &apiError) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get manifest sidecar: %w", err)
+	}
+	defer out.Body.Close() //nolint:errcheck
+
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("read manifest sidecar: %w", err)
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("manifest sidecar %s is empty", sidecarKey)
+	}
+
+	return fields[0], nil
+}
+
+// fileChecksum returns the hex-encoded digest of the file at path, computed
+// with the given algorithm (one of sha256, sha1, crc32c, crc32).
+func fileChecksum(path string, algorithm string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	var hasher hash.Hash
+	switch algorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha1":
+		hasher = sha1.New() //nolint:gosec // required to match S3's sha1 object checksum, not used for security here.
+	case "crc32c":
+		hasher = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "crc32":
+		hasher = crc32.NewIEEE()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("hashing file: %w", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
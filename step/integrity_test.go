@@ -0,0 +1,93 @@
+package step
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// newIntegrityTestServer fakes HeadObject (always reporting the checksum of
+// wantContent) and GetObject (serving corruptContent on the first call and
+// wantContent on every call after), so tests can exercise what happens when a
+// download is corrupted in transit and then retried.
+func newIntegrityTestServer(bucket, key string, wantContent, corruptContent []byte) *httptest.Server {
+	sum := sha256.Sum256(wantContent)
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+
+	var getCalls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+bucket+"/"+key {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			w.Header().Set("x-amz-checksum-sha256", checksum)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		content := wantContent
+		if atomic.AddInt32(&getCalls, 1) == 1 {
+			content = corruptContent
+		}
+
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content)
+	}))
+}
+
+// TestGetObject_ChecksumMismatchRemovesFileAndRetrySucceeds asserts that a
+// corrupted download is caught by verifyIntegrity (removing the partial
+// file instead of leaving it behind) and that a subsequent attempt - the
+// same redownload the retry loop in downloadWithS3Client performs - succeeds
+// once the corruption is gone.
+func TestGetObject_ChecksumMismatchRemovesFileAndRetrySucceeds(t *testing.T) {
+	const bucket = "test-bucket"
+	const key = "archive.tzst"
+
+	wantContent := []byte("archive-contents-for-integrity-test")
+	corruptContent := []byte("not-the-archive-contents-at-all!!!!")
+
+	server := newIntegrityTestServer(bucket, key, wantContent, corruptContent)
+	defer server.Close()
+
+	s := DownloadService{Bucket: bucket, Client: newTestS3Client(t, server.URL)}
+	downloadPath := filepath.Join(t.TempDir(), "archive.tzst")
+	logger := log.NewLogger()
+
+	if err := s.getObject(context.Background(), key, downloadPath, logger); err == nil {
+		t.Fatal("expected the corrupted download to fail integrity verification")
+	} else if !errors.Is(err, errArchiveCorrupted) {
+		t.Fatalf("expected errArchiveCorrupted, got %v", err)
+	}
+
+	if _, err := os.Stat(downloadPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupted archive to be removed, stat returned: %v", err)
+	}
+
+	if err := s.getObject(context.Background(), key, downloadPath, logger); err != nil {
+		t.Fatalf("expected the retried download to succeed, got: %s", err)
+	}
+
+	got, err := os.ReadFile(downloadPath)
+	if err != nil {
+		t.Fatalf("read downloaded archive: %s", err)
+	}
+	if string(got) != string(wantContent) {
+		t.Errorf("expected downloaded contents %q, got %q", wantContent, got)
+	}
+}
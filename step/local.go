@@ -0,0 +1,127 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// LocalDownloadService restores a cache archive from a local directory, such
+// as an NFS or EBS mount shared between self-hosted Bitrise runners.
+type LocalDownloadService struct {
+	Dir string
+}
+
+// Download archive from the configured directory based on the provided keys
+// in params. If there is no match for any of the keys, the error is
+// errCacheNotFound.
+func (s LocalDownloadService) Download(ctx context.Context, params network.DownloadParams, logger log.Logger) (string, error) {
+	truncatedKeys, err := validateKeys(params.CacheKeys)
+	if err != nil {
+		return "", fmt.Errorf("validate keys: %w", err)
+	}
+
+	if s.Dir == "" {
+		return "", fmt.Errorf("directory must not be empty")
+	}
+
+	for _, key := range truncatedKeys {
+		if err := validateLocalKey(key); err != nil {
+			return "", fmt.Errorf("validate keys: %w", err)
+		}
+	}
+
+	firstValidKey, sourcePath, err := s.firstAvailableKey(truncatedKeys, logger)
+	if err != nil {
+		if errors.Is(err, errKeyNotFound) {
+			firstValidKey, sourcePath, err = s.firstAvailableKeyWithPrefix(truncatedKeys)
+		}
+		if err != nil {
+			if errors.Is(err, errKeyNotFound) {
+				return "", errCacheNotFound
+			}
+			return "", fmt.Errorf("matching key: %w", err)
+		}
+	}
+
+	if err := copyFile(sourcePath, params.DownloadPath); err != nil {
+		return "", fmt.Errorf("copy archive: %w", err)
+	}
+
+	return firstValidKey, nil
+}
+
+// validateLocalKey rejects cache keys that could escape s.Dir once joined
+// into a filesystem path, such as those containing ".." or path separators.
+func validateLocalKey(key string) error {
+	if strings.ContainsAny(key, `/\`) || strings.Contains(key, "..") {
+		return fmt.Errorf("key must not contain path separators or '..' (invalid key: %s)", key)
+	}
+
+	return nil
+}
+
+func (s LocalDownloadService) firstAvailableKey(keys []string, logger log.Logger) (string, string, error) {
+	for _, key := range keys {
+		path := filepath.Join(s.Dir, key+".tzst")
+
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				logger.Debugf("archive with key %s not found in %s", key, s.Dir)
+				continue
+			}
+			return "", "", fmt.Errorf("stat %s: %w", path, err)
+		}
+
+		return key, path, nil
+	}
+
+	return "", "", errKeyNotFound
+}
+
+func (s LocalDownloadService) firstAvailableKeyWithPrefix(keys []string) (string, string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return "", "", fmt.Errorf("read dir %s: %w", s.Dir, err)
+	}
+
+	for _, key := range keys {
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), key) {
+				continue
+			}
+
+			match := filepath.Join(s.Dir, entry.Name())
+			return strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())), match, nil
+		}
+	}
+
+	return "", "", errKeyNotFound
+}
+
+func copyFile(sourcePath string, destPath string) error {
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("opening source file: %w", err)
+	}
+	defer src.Close() //nolint:errcheck
+
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("creating destination file: %w", err)
+	}
+	defer dst.Close() //nolint:errcheck
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("copying file contents: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package step
+
+import "testing"
+
+func TestValidateLocalKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "plain key", key: "my-cache-key-v1"},
+		{name: "key with dots but no traversal", key: "cache.key.v1"},
+		{name: "path traversal", key: "../../etc/passwd", wantErr: true},
+		{name: "embedded traversal", key: "some/../key", wantErr: true},
+		{name: "forward slash", key: "a/b", wantErr: true},
+		{name: "backslash", key: `a\b`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLocalKey(tt.key)
+			if tt.wantErr && err == nil {
+				t.Errorf("expected an error for key %q, got nil", tt.key)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("expected no error for key %q, got %s", tt.key, err)
+			}
+		})
+	}
+}
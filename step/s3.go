@@ -2,19 +2,26 @@ package step
 
 import (
 	"context"
+	"crypto/md5" //nolint:gosec // required by the SSE-C GetObject API, not used for security here.
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 	"github.com/bitrise-io/go-steputils/v2/cache/network"
 	"github.com/bitrise-io/go-utils/retry"
@@ -29,16 +36,71 @@ const (
 
 var (
 	errCacheNotFound = errors.New("no cache archive found for the provided keys")
-	errS3KeyNotFound = errors.New("key not found in s3 bucket")
+	errKeyNotFound   = errors.New("key not found in bucket")
 	errNoKeyProvided = errors.New("no keys provided")
 )
 
+// AuthMode selects how the step obtains AWS credentials.
+type AuthMode string
+
+const (
+	// AuthModeDefault uses static access key + secret if provided, otherwise falls
+	// back to the AWS SDK's default credential chain (env vars, shared config,
+	// EC2/ECS instance role, etc).
+	AuthModeDefault AuthMode = ""
+	// AuthModeAssumeRole assumes RoleARN via STS, optionally scoped by ExternalID.
+	AuthModeAssumeRole AuthMode = "assume_role"
+	// AuthModeWebIdentity assumes RoleARN using a web identity token file, as used
+	// for IRSA on EKS.
+	AuthModeWebIdentity AuthMode = "web_identity"
+	// AuthModeInstanceProfile explicitly requests EC2 instance-profile
+	// credentials from the instance metadata service instead of relying on the
+	// default chain's ordering. ECS task-role credentials are not used in this mode.
+	AuthModeInstanceProfile AuthMode = "instance_profile"
+)
+
 type DownloadService struct {
-	Client          *s3.Client
+	Client *s3.Client
+	// kmsClient is lazily set up alongside Client when ClientSideEncryption with
+	// ClientSideKMSKeyARN is in use.
+	kmsClient       *kms.Client
 	Bucket          string
 	Region          string
 	AccessKeyID     string
 	SecretAccessKey string
+	// Endpoint overrides the default AWS S3 endpoint, allowing the step to talk to
+	// S3-compatible services such as MinIO, Ceph RadosGW, Cloudflare R2 or GCS.
+	Endpoint string
+	// UsePathStyle forces path-style addressing (endpoint/bucket/key instead of
+	// bucket.endpoint/key), which most self-hosted S3-compatible services require.
+	UsePathStyle bool
+	// DisableSSL allows connecting to an endpoint over plain HTTP, useful for
+	// local/self-hosted S3-compatible services running without TLS.
+	DisableSSL bool
+	// AuthMode selects how credentials are obtained; defaults to static keys or the
+	// SDK's default chain when empty.
+	AuthMode AuthMode
+	// RoleARN is the IAM role to assume for AuthModeAssumeRole and AuthModeWebIdentity.
+	RoleARN string
+	// ExternalID is passed to STS AssumeRole when set, for AuthModeAssumeRole.
+	ExternalID string
+	// SessionName identifies the assumed-role session in STS and CloudTrail.
+	SessionName string
+	// WebIdentityTokenFile is the path to the projected service account token, as
+	// mounted by EKS for IRSA, used with AuthModeWebIdentity.
+	WebIdentityTokenFile string
+	// SSECustomerAlgorithm and SSECustomerKey enable SSE-C: the archive was
+	// uploaded with a customer-provided key, and the same key must be supplied on
+	// GetObject to decrypt it. SSECustomerKey is the base64-encoded raw key.
+	SSECustomerAlgorithm string
+	SSECustomerKey       string
+	// ClientSideEncryption enables a client-side envelope decryption stage after
+	// download: the downloaded archive is decrypted locally using ClientSideKey
+	// (a base64-encoded raw AES-256 key) or, if that's empty, a KMS-wrapped data
+	// key read from the object's metadata and unwrapped via ClientSideKMSKeyARN.
+	ClientSideEncryption bool
+	ClientSideKey        string
+	ClientSideKMSKeyARN  string
 }
 
 // Download archive from the provided S3 bucket based on the provided keys in params.
@@ -53,18 +115,22 @@ func (s DownloadService) Download(ctx context.Context, params network.DownloadPa
 		return "", fmt.Errorf("bucket must not be empty")
 	}
 
-	cfg, err := loadAWSCredentials(
-		ctx,
-		s.Region,
-		s.AccessKeyID,
-		s.SecretAccessKey,
-		logger,
-	)
+	cfg, err := loadAWSCredentials(ctx, s, logger)
 	if err != nil {
 		return "", fmt.Errorf("load aws credentials: %w", err)
 	}
 
-	s.Client = s3.NewFromConfig(*cfg)
+	s.Client = s3.NewFromConfig(*cfg, func(o *s3.Options) {
+		if s.Endpoint != "" {
+			o.BaseEndpoint = aws.String(endpointURL(s.Endpoint, s.DisableSSL))
+		}
+		o.UsePathStyle = s.UsePathStyle
+	})
+
+	if s.ClientSideEncryption && s.ClientSideKMSKeyARN != "" {
+		s.kmsClient = kms.NewFromConfig(*cfg)
+	}
+
 	return s.downloadWithS3Client(ctx, truncatedKeys, params, logger)
 }
 
@@ -76,14 +142,14 @@ func (s DownloadService) downloadWithS3Client(
 ) (string, error) {
 	firstValidKey, err := s.firstAvailableKey(ctx, cacheKeys, logger)
 	if err != nil {
-		if !errors.Is(errS3KeyNotFound, err) {
+		if !errors.Is(err, errKeyNotFound) {
 			return "", fmt.Errorf("matching key: %w", err)
 		}
 
 		logger.Debugf("Could not match provided cache keys, falling back to find archive by prefix...")
 		firstValidKey, err = s.firstAvailableKeyWithPrefix(ctx, cacheKeys)
 		if err != nil {
-			if errors.Is(errS3KeyNotFound, err) {
+			if errors.Is(err, errKeyNotFound) {
 				return "", errCacheNotFound
 			}
 			return "", fmt.Errorf("finding archive by prefix: %w", err)
@@ -91,7 +157,7 @@ func (s DownloadService) downloadWithS3Client(
 	}
 
 	err = retry.Times(uint(params.NumFullRetries)).Wait(5 * time.Second).TryWithAbort(func(attempt uint) (error, bool) {
-		if err := s.getObject(ctx, firstValidKey, params.DownloadPath); err != nil {
+		if err := s.getObject(ctx, firstValidKey, params.DownloadPath, logger); err != nil {
 			return fmt.Errorf("download object: %w", err), false
 		}
 
@@ -104,35 +170,110 @@ func (s DownloadService) downloadWithS3Client(
 	return firstValidKey, nil
 }
 
+// headResult carries the outcome of a single HeadObject lookup back to
+// firstAvailableKey, tagged with its position in the user-declared key list so
+// priority ordering can be reconstructed from out-of-order completions.
+type headResult struct {
+	index int
+	key   string
+	found bool
+	err   error
+}
+
+// firstAvailableKey fans out a HeadObject lookup per key, bounded by
+// runtime.NumCPU(), instead of checking keys one at a time. The
+// user-declared key priority is still honored: the winner is always the
+// lowest-index found key, even if a lower-priority key's HEAD completes
+// first. Once that winner is known, outstanding HEAD requests are canceled.
 func (s DownloadService) firstAvailableKey(
 	ctx context.Context,
 	keys []string,
 	logger log.Logger,
 ) (string, error) {
-	for _, key := range keys {
-		fileKey := strings.Join([]string{key, "tzst"}, ".")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		_, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(s.Bucket),
-			Key:    aws.String(fileKey),
-		})
-		if err != nil {
-			var apiError smithy.APIError
-			if errors.As(err, &apiError) {
-				switch apiError.(type) {
-				case *types.NotFound:
-					logger.Debugf("archive with key %s not found in bucket", key)
-					continue
-				default:
-					return "", fmt.Errorf("aws api error: %w", err)
-				}
+	concurrency := runtime.NumCPU()
+	if concurrency > len(keys) {
+		concurrency = len(keys)
+	}
+	sem := make(chan struct{}, concurrency)
+
+	results := make(chan headResult, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results <- s.headKey(ctx, i, key, logger)
+		}(i, key)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]headResult, len(keys))
+	for next := 0; next < len(keys); {
+		res, ok := pending[next]
+		if !ok {
+			r, open := <-results
+			if !open {
+				break
 			}
-			return "", fmt.Errorf("generic aws error: %w", err)
+			pending[r.index] = r
+			continue
 		}
 
-		return key, nil
+		delete(pending, next)
+		if res.err != nil {
+			return "", res.err
+		}
+		if res.found {
+			return res.key, nil
+		}
+		next++
 	}
-	return "", errS3KeyNotFound
+
+	return "", errKeyNotFound
+}
+
+// headKey performs a single HeadObject lookup for key and reports the
+// outcome as a headResult, distinguishing "not found" from a genuine AWS
+// error the way firstAvailableKey's caller did before concurrency was added.
+func (s DownloadService) headKey(ctx context.Context, index int, key string, logger log.Logger) headResult {
+	fileKey := strings.Join([]string{key, "tzst"}, ".")
+
+	sseParams, err := s.sseCustomerParams()
+	if err != nil {
+		return headResult{index: index, err: fmt.Errorf("sse-c params: %w", err)}
+	}
+
+	_, err = s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(fileKey),
+		SSECustomerAlgorithm: sseParams.Algorithm,
+		SSECustomerKey:       sseParams.Key,
+		SSECustomerKeyMD5:    sseParams.KeyMD5,
+	})
+	if err != nil {
+		var apiError smithy.APIError
+		if errors.As(err, &apiError) {
+			if _, ok := apiError.(*types.NotFound); ok {
+				logger.Debugf("archive with key %s not found in bucket", key)
+				return headResult{index: index, key: key, found: false}
+			}
+			return headResult{index: index, err: fmt.Errorf("aws api error: %w", err)}
+		}
+		return headResult{index: index, err: fmt.Errorf("generic aws error: %w", err)}
+	}
+
+	return headResult{index: index, key: key, found: true}
 }
 
 func (s DownloadService) firstAvailableKeyWithPrefix(ctx context.Context, keys []string) (string, error) {
@@ -155,10 +296,38 @@ func (s DownloadService) firstAvailableKeyWithPrefix(ctx context.Context, keys [
 		}
 	}
 
-	return "", errS3KeyNotFound
+	return "", errKeyNotFound
 }
 
-func (s *DownloadService) getObject(ctx context.Context, key string, downloadPath string) error {
+func (s *DownloadService) getObject(ctx context.Context, key string, downloadPath string, logger log.Logger) error {
+	sseParams, err := s.sseCustomerParams()
+	if err != nil {
+		return fmt.Errorf("sse-c params: %w", err)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(key),
+		SSECustomerAlgorithm: sseParams.Algorithm,
+		SSECustomerKey:       sseParams.Key,
+		SSECustomerKeyMD5:    sseParams.KeyMD5,
+	}
+
+	var objectMetadata map[string]string
+	if s.ClientSideEncryption {
+		head, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:               aws.String(s.Bucket),
+			Key:                  aws.String(key),
+			SSECustomerAlgorithm: sseParams.Algorithm,
+			SSECustomerKey:       sseParams.Key,
+			SSECustomerKeyMD5:    sseParams.KeyMD5,
+		})
+		if err != nil {
+			return fmt.Errorf("head object for client-side decryption metadata: %w", err)
+		}
+		objectMetadata = head.Metadata
+	}
+
 	file, err := os.Create(downloadPath)
 	if err != nil {
 		return fmt.Errorf("creating file: %w", err)
@@ -171,36 +340,111 @@ func (s *DownloadService) getObject(ctx context.Context, key string, downloadPat
 		d.Concurrency = runtime.NumCPU()
 	})
 
-	_, err = downloader.Download(ctx, file, &s3.GetObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(key),
-	})
+	_, err = downloader.Download(ctx, file, input)
 	if err != nil {
 		return fmt.Errorf("get object: %w", err)
 	}
 
+	if err := s.verifyIntegrity(ctx, key, downloadPath, logger); err != nil {
+		return fmt.Errorf("verify integrity: %w", err)
+	}
+
+	if s.ClientSideEncryption {
+		if err := s.decryptClientSide(ctx, downloadPath, objectMetadata); err != nil {
+			return fmt.Errorf("client-side decrypt: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func loadAWSCredentials(
-	ctx context.Context,
-	region string,
-	accessKeyID string,
-	secretKey string,
-	logger log.Logger,
-) (*aws.Config, error) {
-	if region == "" {
+// sseCustomerParams holds the SSE-C headers S3 requires on every request
+// against an SSE-C object, including HeadObject, not just GetObject.
+type sseCustomerParams struct {
+	Algorithm *string
+	Key       *string
+	KeyMD5    *string
+}
+
+// sseCustomerParams derives the SSE-C headers from s.SSECustomerAlgorithm and
+// s.SSECustomerKey, or returns a zero value when SSE-C isn't configured.
+func (s DownloadService) sseCustomerParams() (sseCustomerParams, error) {
+	if s.SSECustomerAlgorithm == "" || s.SSECustomerKey == "" {
+		return sseCustomerParams{}, nil
+	}
+
+	md5Sum, err := sseCustomerKeyMD5(s.SSECustomerKey)
+	if err != nil {
+		return sseCustomerParams{}, fmt.Errorf("compute sse-c key md5: %w", err)
+	}
+
+	return sseCustomerParams{
+		Algorithm: aws.String(s.SSECustomerAlgorithm),
+		Key:       aws.String(s.SSECustomerKey),
+		KeyMD5:    aws.String(md5Sum),
+	}, nil
+}
+
+// sseCustomerKeyMD5 returns the base64-encoded MD5 digest of a base64-encoded
+// SSE-C customer key, as required by the SSECustomerKeyMD5 GetObject parameter.
+func sseCustomerKeyMD5(base64Key string) (string, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(base64Key)
+	if err != nil {
+		return "", fmt.Errorf("decode sse-c key: %w", err)
+	}
+
+	sum := md5.Sum(rawKey) //nolint:gosec // MD5 is required by the SSE-C GetObject API, not used for security here.
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// endpointURL normalizes a user-provided custom endpoint (with or without a scheme)
+// into a full URL, honoring disableSSL when no scheme was given.
+func endpointURL(endpoint string, disableSSL bool) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+
+	scheme := "https"
+	if disableSSL {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, endpoint)
+}
+
+func loadAWSCredentials(ctx context.Context, s DownloadService, logger log.Logger) (*aws.Config, error) {
+	if s.Region == "" {
 		return nil, fmt.Errorf("region must not be empty")
 	}
 
 	opts := []func(*config.LoadOptions) error{
-		config.WithRegion(region),
+		config.WithRegion(s.Region),
 	}
 
-	if accessKeyID != "" && secretKey != "" {
-		logger.Debugf("aws credentials provided, using them...")
-		opts = append(opts,
-			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretKey, "")))
+	switch s.AuthMode {
+	case AuthModeAssumeRole:
+		if s.RoleARN == "" {
+			return nil, fmt.Errorf("role arn must not be empty for assume_role auth mode")
+		}
+		logger.Debugf("assuming role %s via STS...", s.RoleARN)
+	case AuthModeWebIdentity:
+		if s.RoleARN == "" {
+			return nil, fmt.Errorf("role arn must not be empty for web_identity auth mode")
+		}
+		if s.WebIdentityTokenFile == "" {
+			return nil, fmt.Errorf("web identity token file must not be empty for web_identity auth mode")
+		}
+		logger.Debugf("assuming role %s via web identity token...", s.RoleARN)
+	case AuthModeInstanceProfile:
+		logger.Debugf("using EC2 instance profile credentials...")
+	case AuthModeDefault:
+		if s.AccessKeyID != "" && s.SecretAccessKey != "" {
+			logger.Debugf("aws credentials provided, using them...")
+			opts = append(opts,
+				config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.AccessKeyID, s.SecretAccessKey, "")))
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth mode: %s", s.AuthMode)
 	}
 
 	cfg, err := config.LoadDefaultConfig(ctx, opts...)
@@ -208,6 +452,30 @@ func loadAWSCredentials(
 		return nil, fmt.Errorf("failed to load config, %v", err)
 	}
 
+	switch s.AuthMode {
+	case AuthModeAssumeRole:
+		stsClient := sts.NewFromConfig(cfg)
+		provider := stscreds.NewAssumeRoleProvider(stsClient, s.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if s.ExternalID != "" {
+				o.ExternalID = aws.String(s.ExternalID)
+			}
+			if s.SessionName != "" {
+				o.RoleSessionName = s.SessionName
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	case AuthModeWebIdentity:
+		stsClient := sts.NewFromConfig(cfg)
+		provider := stscreds.NewWebIdentityRoleProvider(stsClient, s.RoleARN, stscreds.IdentityTokenFile(s.WebIdentityTokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+			if s.SessionName != "" {
+				o.RoleSessionName = s.SessionName
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	case AuthModeInstanceProfile:
+		cfg.Credentials = aws.NewCredentialsCache(ec2rolecreds.New())
+	}
+
 	return &cfg, nil
 }
 
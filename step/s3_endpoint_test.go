@@ -0,0 +1,81 @@
+package step
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+type recordedRequest struct {
+	method string
+	path   string
+	host   string
+}
+
+// TestDownload_CustomEndpointUsesPathStyleAndCustomHost verifies that a
+// custom Endpoint and UsePathStyle route requests to the configured host with
+// the bucket folded into the path, instead of the default AWS virtual-hosted
+// *.amazonaws.com addressing — the behavior MinIO, Ceph, R2 and GCS's S3
+// interop API need.
+func TestDownload_CustomEndpointUsesPathStyleAndCustomHost(t *testing.T) {
+	var mu sync.Mutex
+	var requests []recordedRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, recordedRequest{method: r.Method, path: r.URL.Path, host: r.Host})
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parse test server url: %s", err)
+	}
+
+	s := DownloadService{
+		Bucket:          "test-bucket",
+		Region:          "us-east-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "SECRET",
+		Endpoint:        server.URL,
+		UsePathStyle:    true,
+	}
+
+	params := network.DownloadParams{
+		CacheKeys:      []string{"some-key"},
+		NumFullRetries: 0,
+		DownloadPath:   filepath.Join(t.TempDir(), "archive.tzst"),
+	}
+
+	// The fake server always answers 404, so Download is expected to fail —
+	// what matters here is how the requests that led to that failure were shaped.
+	_, _ = s.Download(context.Background(), params, log.NewLogger())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(requests) == 0 {
+		t.Fatal("expected at least one request to reach the custom endpoint")
+	}
+
+	for _, req := range requests {
+		if req.host != serverURL.Host {
+			t.Errorf("expected request host %q, got %q (non-AWS host header not honored)", serverURL.Host, req.host)
+		}
+		// Bucket-level requests (the ListObjectsV2 prefix fallback) address
+		// /test-bucket itself; object requests address /test-bucket/<key>.
+		if req.path != "/test-bucket" && !strings.HasPrefix(req.path, "/test-bucket/") {
+			t.Errorf("expected a path-style request path of /test-bucket or prefixed with /test-bucket/, got %q", req.path)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// keyBehavior controls how the fake S3 server responds to a HeadObject
+// lookup for a given archive key, letting tests force a specific completion
+// order across concurrent requests.
+type keyBehavior struct {
+	delay  time.Duration
+	status int
+}
+
+func newPriorityTestServer(bucket string, behaviors map[string]keyBehavior) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileKey := strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")
+
+		b, ok := behaviors[fileKey]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if b.delay > 0 {
+			time.Sleep(b.delay)
+		}
+		w.WriteHeader(b.status)
+	}))
+}
+
+func newTestS3Client(tb testing.TB, serverURL string) *s3.Client {
+	tb.Helper()
+
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(serverURL)
+		o.UsePathStyle = true
+	})
+}
+
+// TestFirstAvailableKey_PreservesPriorityOrder asserts that the concurrent
+// HEAD fan-out in firstAvailableKey always picks the lowest-index (highest
+// priority) matching key, even when a lower-priority key's HEAD completes
+// first.
+func TestFirstAvailableKey_PreservesPriorityOrder(t *testing.T) {
+	const bucket = "test-bucket"
+
+	tests := []struct {
+		name      string
+		keys      []string
+		behaviors map[string]keyBehavior
+		wantKey   string
+		wantErr   bool
+	}{
+		{
+			name: "highest priority key wins even when a lower priority key's HEAD completes first",
+			keys: []string{"high-priority", "low-priority"},
+			behaviors: map[string]keyBehavior{
+				"high-priority.tzst": {delay: 50 * time.Millisecond, status: http.StatusOK},
+				"low-priority.tzst":  {delay: 0, status: http.StatusOK},
+			},
+			wantKey: "high-priority",
+		},
+		{
+			name: "falls through to the next priority key when the highest isn't found",
+			keys: []string{"missing", "present"},
+			behaviors: map[string]keyBehavior{
+				"present.tzst": {delay: 0, status: http.StatusOK},
+			},
+			wantKey: "present",
+		},
+		{
+			name:      "returns errKeyNotFound when no key matches",
+			keys:      []string{"missing-one", "missing-two"},
+			behaviors: map[string]keyBehavior{},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := newPriorityTestServer(bucket, tt.behaviors)
+			defer server.Close()
+
+			s := DownloadService{Bucket: bucket, Client: newTestS3Client(t, server.URL)}
+
+			got, err := s.firstAvailableKey(context.Background(), tt.keys, log.NewLogger())
+			if tt.wantErr {
+				if !errors.Is(err, errKeyNotFound) {
+					t.Fatalf("expected errKeyNotFound, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.wantKey {
+				t.Errorf("expected key %q, got %q", tt.wantKey, got)
+			}
+		})
+	}
+}
+
+// BenchmarkFirstAvailableKey measures the concurrent HEAD fan-out across a
+// full set of keys where only the lowest-priority one actually exists, the
+// worst case for the old sequential implementation.
+func BenchmarkFirstAvailableKey(b *testing.B) {
+	const bucket = "bench-bucket"
+
+	keys := make([]string, maxKeyCount)
+	behaviors := make(map[string]keyBehavior, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		behaviors[keys[i]+".tzst"] = keyBehavior{delay: 5 * time.Millisecond, status: http.StatusNotFound}
+	}
+	behaviors[keys[len(keys)-1]+".tzst"] = keyBehavior{delay: 5 * time.Millisecond, status: http.StatusOK}
+
+	server := newPriorityTestServer(bucket, behaviors)
+	defer server.Close()
+
+	s := DownloadService{Bucket: bucket, Client: newTestS3Client(b, server.URL)}
+	logger := log.NewLogger()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.firstAvailableKey(context.Background(), keys, logger); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	}
+}